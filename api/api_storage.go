@@ -0,0 +1,34 @@
+package api
+
+import (
+	"context"
+)
+
+// StorageMiner is the interface exposed by a running storage miner process.
+type StorageMiner interface {
+	// WindowPoStEvents streams the typed lifecycle events emitted by the
+	// miner's WindowPoStScheduler (proof generation, message submission,
+	// skipped partitions), for operators who want per-deadline visibility without
+	// parsing logs. The channel is closed when ctx is cancelled.
+	WindowPoStEvents(ctx context.Context) (<-chan WindowPoStEvent, error)
+}
+
+// WindowPoStEventType identifies the stage of the window PoSt lifecycle a
+// WindowPoStEvent describes.
+type WindowPoStEventType int
+
+const (
+	GeneratePoStStarted WindowPoStEventType = iota
+	GeneratePoStFinished
+	SubmitPoStStarted
+	SubmitPoStConfirmed
+	PartitionSkipped
+)
+
+// WindowPoStEvent is a single, typed step in the window PoSt lifecycle for
+// one deadline.
+type WindowPoStEvent struct {
+	Type     WindowPoStEventType
+	Deadline uint64
+	Error    error
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+	"golang.org/x/xerrors"
+)
+
+// dealSnapshot is a single point-in-time sample of the deal-stats server's
+// view of the market, taken once per poll interval so the history
+// endpoints don't have to recompute dealList() on every request.
+type dealSnapshot struct {
+	Epoch       int64
+	Count       int64
+	TotalBytes  int64
+	ClientStats []*clientStats
+}
+
+// Store persists dealSnapshots and answers historical queries over them.
+type Store interface {
+	// Put records a new snapshot, replacing any snapshot already recorded
+	// for the same epoch.
+	Put(ctx context.Context, snap *dealSnapshot) error
+	// Latest returns the recorded snapshot with the highest epoch, or nil
+	// if none have been recorded yet.
+	Latest(ctx context.Context) (*dealSnapshot, error)
+	// At returns the snapshot recorded at the given epoch, or nil if none
+	// was recorded there.
+	At(ctx context.Context, epoch int64) (*dealSnapshot, error)
+	// Range returns every snapshot recorded between since and until
+	// (inclusive), ordered by epoch ascending.
+	Range(ctx context.Context, since, until int64) ([]*dealSnapshot, error)
+}
+
+// openStore builds the Store named by kind. dsn is the sqlite file path or
+// the postgres connection string, and is ignored for the memory store.
+func openStore(kind, dsn string) (Store, error) {
+	switch kind {
+	case "", "memory":
+		return newMemStore(), nil
+	case "sqlite":
+		return newSQLiteStore(dsn)
+	case "postgres":
+		return newPostgresStore(dsn)
+	default:
+		return nil, xerrors.Errorf("unknown deal-stats store %q", kind)
+	}
+}
+
+// memStore is the default, in-process Store; snapshots are kept sorted by
+// epoch and are lost on restart.
+type memStore struct {
+	mu        sync.RWMutex
+	snapshots []*dealSnapshot
+}
+
+func newMemStore() *memStore {
+	return &memStore{}
+}
+
+func (s *memStore) Put(ctx context.Context, snap *dealSnapshot) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, existing := range s.snapshots {
+		if existing.Epoch == snap.Epoch {
+			s.snapshots[i] = snap
+			return nil
+		}
+	}
+
+	s.snapshots = append(s.snapshots, snap)
+	sort.Slice(s.snapshots, func(i, j int) bool {
+		return s.snapshots[i].Epoch < s.snapshots[j].Epoch
+	})
+	return nil
+}
+
+func (s *memStore) Latest(ctx context.Context) (*dealSnapshot, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if len(s.snapshots) == 0 {
+		return nil, nil
+	}
+	return s.snapshots[len(s.snapshots)-1], nil
+}
+
+func (s *memStore) At(ctx context.Context, epoch int64) (*dealSnapshot, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, snap := range s.snapshots {
+		if snap.Epoch == epoch {
+			return snap, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *memStore) Range(ctx context.Context, since, until int64) ([]*dealSnapshot, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var out []*dealSnapshot
+	for _, snap := range s.snapshots {
+		if snap.Epoch >= since && snap.Epoch <= until {
+			out = append(out, snap)
+		}
+	}
+	return out, nil
+}
+
+// sqlDialect distinguishes the bind-parameter syntax of the sql.DB drivers
+// sqlStore is used with.
+type sqlDialect int
+
+const (
+	dialectSQLite sqlDialect = iota
+	dialectPostgres
+)
+
+// sqlStore is a Store backed by database/sql, shared by the sqlite and
+// postgres backends; the only thing that differs between them is the
+// driver used to open db and the bind-parameter syntax in ph.
+type sqlStore struct {
+	db      *sql.DB
+	dialect sqlDialect
+}
+
+func newSQLiteStore(path string) (*sqlStore, error) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, xerrors.Errorf("opening sqlite deal-stats store: %w", err)
+	}
+	return newSQLStore(db, dialectSQLite)
+}
+
+func newPostgresStore(dsn string) (*sqlStore, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, xerrors.Errorf("opening postgres deal-stats store: %w", err)
+	}
+	return newSQLStore(db, dialectPostgres)
+}
+
+func newSQLStore(db *sql.DB, dialect sqlDialect) (*sqlStore, error) {
+	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS deal_snapshots (
+		epoch BIGINT PRIMARY KEY,
+		count BIGINT NOT NULL,
+		total_bytes BIGINT NOT NULL,
+		client_stats TEXT NOT NULL
+	)`); err != nil {
+		return nil, xerrors.Errorf("creating deal_snapshots table: %w", err)
+	}
+
+	return &sqlStore{db: db, dialect: dialect}, nil
+}
+
+// ph returns the n-th (1-indexed) bind parameter placeholder for s's
+// dialect.
+func (s *sqlStore) ph(n int) string {
+	if s.dialect == dialectPostgres {
+		return fmt.Sprintf("$%d", n)
+	}
+	return "?"
+}
+
+func (s *sqlStore) Put(ctx context.Context, snap *dealSnapshot) error {
+	data, err := json.Marshal(snap.ClientStats)
+	if err != nil {
+		return xerrors.Errorf("marshaling client stats: %w", err)
+	}
+
+	existing, err := s.At(ctx, snap.Epoch)
+	if err != nil {
+		return err
+	}
+
+	if existing != nil {
+		_, err = s.db.ExecContext(ctx,
+			fmt.Sprintf(`UPDATE deal_snapshots SET count = %s, total_bytes = %s, client_stats = %s WHERE epoch = %s`,
+				s.ph(1), s.ph(2), s.ph(3), s.ph(4)),
+			snap.Count, snap.TotalBytes, data, snap.Epoch)
+	} else {
+		_, err = s.db.ExecContext(ctx,
+			fmt.Sprintf(`INSERT INTO deal_snapshots (epoch, count, total_bytes, client_stats) VALUES (%s, %s, %s, %s)`,
+				s.ph(1), s.ph(2), s.ph(3), s.ph(4)),
+			snap.Epoch, snap.Count, snap.TotalBytes, data)
+	}
+	if err != nil {
+		return xerrors.Errorf("persisting deal snapshot: %w", err)
+	}
+
+	return nil
+}
+
+func (s *sqlStore) Latest(ctx context.Context) (*dealSnapshot, error) {
+	row := s.db.QueryRowContext(ctx, `SELECT epoch, count, total_bytes, client_stats FROM deal_snapshots ORDER BY epoch DESC LIMIT 1`)
+	return s.scanSnapshot(row)
+}
+
+func (s *sqlStore) At(ctx context.Context, epoch int64) (*dealSnapshot, error) {
+	row := s.db.QueryRowContext(ctx,
+		fmt.Sprintf(`SELECT epoch, count, total_bytes, client_stats FROM deal_snapshots WHERE epoch = %s`, s.ph(1)),
+		epoch)
+	return s.scanSnapshot(row)
+}
+
+func (s *sqlStore) Range(ctx context.Context, since, until int64) ([]*dealSnapshot, error) {
+	rows, err := s.db.QueryContext(ctx,
+		fmt.Sprintf(`SELECT epoch, count, total_bytes, client_stats FROM deal_snapshots WHERE epoch >= %s AND epoch <= %s ORDER BY epoch ASC`, s.ph(1), s.ph(2)),
+		since, until)
+	if err != nil {
+		return nil, xerrors.Errorf("querying deal snapshots: %w", err)
+	}
+	defer rows.Close() // nolint:errcheck
+
+	var out []*dealSnapshot
+	for rows.Next() {
+		snap, err := s.scanSnapshotRow(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, snap)
+	}
+
+	return out, rows.Err()
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func (s *sqlStore) scanSnapshot(row rowScanner) (*dealSnapshot, error) {
+	snap, err := s.scanSnapshotRow(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return snap, err
+}
+
+func (s *sqlStore) scanSnapshotRow(row rowScanner) (*dealSnapshot, error) {
+	var snap dealSnapshot
+	var data []byte
+
+	if err := row.Scan(&snap.Epoch, &snap.Count, &snap.TotalBytes, &data); err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &snap.ClientStats); err != nil {
+		return nil, xerrors.Errorf("unmarshaling client stats: %w", err)
+	}
+
+	return &snap, nil
+}
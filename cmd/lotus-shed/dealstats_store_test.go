@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemStorePutAndAt(t *testing.T) {
+	ctx := context.Background()
+	s := newMemStore()
+
+	snap := &dealSnapshot{Epoch: 100, Count: 3, TotalBytes: 4096}
+	require.NoError(t, s.Put(ctx, snap))
+
+	got, err := s.At(ctx, 100)
+	require.NoError(t, err)
+	require.Equal(t, snap, got)
+
+	missing, err := s.At(ctx, 200)
+	require.NoError(t, err)
+	require.Nil(t, missing)
+}
+
+func TestMemStorePutReplacesSameEpoch(t *testing.T) {
+	ctx := context.Background()
+	s := newMemStore()
+
+	require.NoError(t, s.Put(ctx, &dealSnapshot{Epoch: 100, Count: 1}))
+	require.NoError(t, s.Put(ctx, &dealSnapshot{Epoch: 100, Count: 2}))
+
+	got, err := s.At(ctx, 100)
+	require.NoError(t, err)
+	require.EqualValues(t, 2, got.Count)
+}
+
+func TestMemStoreLatest(t *testing.T) {
+	ctx := context.Background()
+	s := newMemStore()
+
+	_, err := s.Latest(ctx)
+	require.NoError(t, err)
+
+	require.NoError(t, s.Put(ctx, &dealSnapshot{Epoch: 100}))
+	require.NoError(t, s.Put(ctx, &dealSnapshot{Epoch: 200}))
+
+	latest, err := s.Latest(ctx)
+	require.NoError(t, err)
+	require.EqualValues(t, 200, latest.Epoch)
+}
+
+func TestMemStoreRange(t *testing.T) {
+	ctx := context.Background()
+	s := newMemStore()
+
+	for _, epoch := range []int64{100, 150, 200, 250} {
+		require.NoError(t, s.Put(ctx, &dealSnapshot{Epoch: epoch}))
+	}
+
+	snaps, err := s.Range(ctx, 150, 200)
+	require.NoError(t, err)
+	require.Len(t, snaps, 2)
+	require.EqualValues(t, 150, snaps[0].Epoch)
+	require.EqualValues(t, 200, snaps[1].Epoch)
+}
+
+func TestOpenStoreUnknownKind(t *testing.T) {
+	_, err := openStore("bogus", "")
+	require.Error(t, err)
+}
@@ -3,8 +3,15 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"math"
 	"net"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/xerrors"
 
 	"github.com/filecoin-project/go-address"
 	"github.com/filecoin-project/lotus/api"
@@ -14,9 +21,14 @@ import (
 )
 
 type dealStatsServer struct {
-	api api.FullNode
+	api   api.FullNode
+	store Store
 }
 
+// cacheMu guards walletCache and knownFilteredClients: dealList runs
+// concurrently in both the request-handling goroutines and pollSnapshots'
+// background ticker.
+var cacheMu sync.Mutex
 var walletCache map[address.Address]address.Address
 var knownFilteredClients map[address.Address]bool
 
@@ -57,11 +69,14 @@ func (dss *dealStatsServer) dealList() (int64, map[string]dealData) {
 	}
 	for _, m := range miners {
 		info, _ := dss.api.StateMinerInfo(ctx, m, head.Key())
+
+		cacheMu.Lock()
 		knownFilteredClients[info.Owner] = true
 		knownFilteredClients[info.Worker] = true
 		for _, a := range info.ControlAddresses {
 			knownFilteredClients[a] = true
 		}
+		cacheMu.Unlock()
 	}
 
 	deals, err := dss.api.StateMarketDeals(ctx, head.Key())
@@ -80,12 +95,22 @@ func (dss *dealStatsServer) dealList() (int64, map[string]dealData) {
 		// }
 
 		dw := dealData{deal: d}
-		if _, found := walletCache[d.Proposal.Client]; !found {
-			walletCache[d.Proposal.Client], _ = dss.api.StateAccountKey(ctx, d.Proposal.Client, head.Key())
+
+		cacheMu.Lock()
+		wallet, found := walletCache[d.Proposal.Client]
+		cacheMu.Unlock()
+		if !found {
+			wallet, _ = dss.api.StateAccountKey(ctx, d.Proposal.Client, head.Key())
+			cacheMu.Lock()
+			walletCache[d.Proposal.Client] = wallet
+			cacheMu.Unlock()
 		}
-		dw.wallet = walletCache[d.Proposal.Client]
+		dw.wallet = wallet
 
-		if knownFilteredClients[d.Proposal.Client] {
+		cacheMu.Lock()
+		filtered := knownFilteredClients[d.Proposal.Client]
+		cacheMu.Unlock()
+		if filtered {
 			continue
 		}
 
@@ -196,13 +221,9 @@ type clientStats struct {
 	providers map[address.Address]bool
 }
 
-func (dss *dealStatsServer) handleStorageClientStats(w http.ResponseWriter, r *http.Request) {
-	epoch, deals := dss.dealList()
-	if epoch == 0 {
-		w.WriteHeader(500)
-		return
-	}
-
+// computeClientStats aggregates deals by client, producing one clientStats
+// entry per client.
+func computeClientStats(deals map[string]dealData) []*clientStats {
 	stats := make(map[address.Address]*clientStats)
 
 	for _, d := range deals {
@@ -222,17 +243,27 @@ func (dss *dealStatsServer) handleStorageClientStats(w http.ResponseWriter, r *h
 		st.NumDeals++
 	}
 
-	out := clientStatsOutput{
-		Epoch:    epoch,
-		Endpoint: "CLIENT_DEAL_STATS",
-		Payload:  make([]*clientStats, 0, len(stats)),
-	}
-
+	out := make([]*clientStats, 0, len(stats))
 	for _, cso := range stats {
 		cso.NumCids = len(cso.cids)
 		cso.NumMiners = len(cso.providers)
+		out = append(out, cso)
+	}
+
+	return out
+}
+
+func (dss *dealStatsServer) handleStorageClientStats(w http.ResponseWriter, r *http.Request) {
+	epoch, deals := dss.dealList()
+	if epoch == 0 {
+		w.WriteHeader(500)
+		return
+	}
 
-		out.Payload = append(out.Payload, cso)
+	out := clientStatsOutput{
+		Epoch:    epoch,
+		Endpoint: "CLIENT_DEAL_STATS",
+		Payload:  computeClientStats(deals),
 	}
 
 	if err := json.NewEncoder(w).Encode(out); err != nil {
@@ -241,9 +272,184 @@ func (dss *dealStatsServer) handleStorageClientStats(w http.ResponseWriter, r *h
 	}
 }
 
+// snapshotFromDeals builds the dealSnapshot persisted for epoch from the
+// result of dealList().
+func snapshotFromDeals(epoch int64, deals map[string]dealData) *dealSnapshot {
+	var totalBytes int64
+	for _, d := range deals {
+		totalBytes += int64(d.deal.Proposal.PieceSize.Unpadded())
+	}
+
+	return &dealSnapshot{
+		Epoch:       epoch,
+		Count:       int64(len(deals)),
+		TotalBytes:  totalBytes,
+		ClientStats: computeClientStats(deals),
+	}
+}
+
+// pollSnapshots periodically persists a dealSnapshot to dss.store, skipping
+// epochs that come sooner than everyEpochs after the last one recorded.
+func (dss *dealStatsServer) pollSnapshots(ctx context.Context, everyEpochs int64) {
+	lastEpoch := int64(-1)
+
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			epoch, deals := dss.dealList()
+			if epoch == 0 {
+				continue
+			}
+			if lastEpoch >= 0 && epoch-lastEpoch < everyEpochs {
+				continue
+			}
+			if err := dss.store.Put(ctx, snapshotFromDeals(epoch, deals)); err != nil {
+				log.Warnf("failed to persist deal snapshot: %s", err)
+				continue
+			}
+			lastEpoch = epoch
+		}
+	}
+}
+
+// snapshotsForRequest resolves the epoch/since/until query parameters on r
+// against dss.store.
+func (dss *dealStatsServer) snapshotsForRequest(r *http.Request) ([]*dealSnapshot, error) {
+	q := r.URL.Query()
+
+	if e := q.Get("epoch"); e != "" {
+		epoch, err := strconv.ParseInt(e, 10, 64)
+		if err != nil {
+			return nil, xerrors.Errorf("parsing epoch: %w", err)
+		}
+
+		snap, err := dss.store.At(r.Context(), epoch)
+		if err != nil || snap == nil {
+			return nil, err
+		}
+		return []*dealSnapshot{snap}, nil
+	}
+
+	since := int64(0)
+	if v := q.Get("since"); v != "" {
+		parsed, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return nil, xerrors.Errorf("parsing since: %w", err)
+		}
+		since = parsed
+	}
+
+	until := int64(math.MaxInt64)
+	if v := q.Get("until"); v != "" {
+		parsed, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return nil, xerrors.Errorf("parsing until: %w", err)
+		}
+		until = parsed
+	}
+
+	return dss.store.Range(r.Context(), since, until)
+}
+
+func (dss *dealStatsServer) handleHistoryCount(w http.ResponseWriter, r *http.Request) {
+	snaps, err := dss.snapshotsForRequest(r)
+	if err != nil {
+		w.WriteHeader(400)
+		return
+	}
+
+	samples := make([]dealCountResp, 0, len(snaps))
+	for _, snap := range snaps {
+		samples = append(samples, dealCountResp{
+			Endpoint: "COUNT_DEALS",
+			Epoch:    snap.Epoch,
+			Payload:  snap.Count,
+		})
+	}
+
+	if err := json.NewEncoder(w).Encode(samples); err != nil {
+		log.Warnf("failed to write back deal count history response: %s", err)
+	}
+}
+
+func (dss *dealStatsServer) handleHistoryTotalReal(w http.ResponseWriter, r *http.Request) {
+	snaps, err := dss.snapshotsForRequest(r)
+	if err != nil {
+		w.WriteHeader(400)
+		return
+	}
+
+	samples := make([]dealTotalResp, 0, len(snaps))
+	for _, snap := range snaps {
+		samples = append(samples, dealTotalResp{
+			Endpoint: "DEAL_BYTES",
+			Epoch:    snap.Epoch,
+			Payload:  snap.TotalBytes,
+		})
+	}
+
+	if err := json.NewEncoder(w).Encode(samples); err != nil {
+		log.Warnf("failed to write back deal total history response: %s", err)
+	}
+}
+
+type clientStatsSample struct {
+	Epoch int64        `json:"epoch"`
+	Stats *clientStats `json:"stats"`
+}
+
+func (dss *dealStatsServer) handleHistoryClientStats(w http.ResponseWriter, r *http.Request) {
+	clientStr := strings.TrimPrefix(r.URL.Path, "/api/storagedeal/history/clientstats/")
+	client, err := address.NewFromString(clientStr)
+	if err != nil {
+		w.WriteHeader(400)
+		return
+	}
+
+	snaps, err := dss.snapshotsForRequest(r)
+	if err != nil {
+		w.WriteHeader(400)
+		return
+	}
+
+	samples := make([]*clientStatsSample, 0, len(snaps))
+	for _, snap := range snaps {
+		for _, cs := range snap.ClientStats {
+			if cs.Client == client {
+				samples = append(samples, &clientStatsSample{Epoch: snap.Epoch, Stats: cs})
+				break
+			}
+		}
+	}
+
+	if err := json.NewEncoder(w).Encode(samples); err != nil {
+		log.Warnf("failed to write back client stats history response: %s", err)
+	}
+}
+
 var serveDealStatsCmd = &cli.Command{
-	Name:  "serve-deal-stats",
-	Flags: []cli.Flag{},
+	Name: "serve-deal-stats",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "store",
+			Usage: "deal-stats history backend: memory, sqlite, or postgres",
+			Value: "memory",
+		},
+		&cli.StringFlag{
+			Name:  "store-dsn",
+			Usage: "data source for the sqlite or postgres store (sqlite: file path, postgres: connection string)",
+		},
+		&cli.Int64Flag{
+			Name:  "poll-interval-epochs",
+			Usage: "minimum number of epochs between persisted deal-stats snapshots",
+			Value: 120,
+		},
+	},
 	Action: func(cctx *cli.Context) error {
 		api, closer, err := lcli.GetFullNodeAPI(cctx)
 		if err != nil {
@@ -253,15 +459,23 @@ var serveDealStatsCmd = &cli.Command{
 		defer closer()
 		ctx := lcli.ReqContext(cctx)
 
-		_ = ctx
+		store, err := openStore(cctx.String("store"), cctx.String("store-dsn"))
+		if err != nil {
+			return xerrors.Errorf("opening deal-stats store: %w", err)
+		}
+
+		dss := &dealStatsServer{api: api, store: store}
 
-		dss := &dealStatsServer{api}
+		go dss.pollSnapshots(ctx, cctx.Int64("poll-interval-epochs"))
 
 		mux := &http.ServeMux{}
 		mux.HandleFunc("/api/storagedeal/count", dss.handleStorageDealCount)
 		mux.HandleFunc("/api/storagedeal/averagesize", dss.handleStorageDealAverageSize)
 		mux.HandleFunc("/api/storagedeal/totalreal", dss.handleStorageDealTotalReal)
 		mux.HandleFunc("/api/storagedeal/clientstats", dss.handleStorageClientStats)
+		mux.HandleFunc("/api/storagedeal/history/count", dss.handleHistoryCount)
+		mux.HandleFunc("/api/storagedeal/history/totalreal", dss.handleHistoryTotalReal)
+		mux.HandleFunc("/api/storagedeal/history/clientstats/", dss.handleHistoryClientStats)
 
 		s := &http.Server{
 			Addr:    ":7272",
@@ -0,0 +1,112 @@
+package storage
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/filecoin-project/lotus/api"
+)
+
+var (
+	metricPoStGenerationDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "lotus",
+		Subsystem: "wdpost",
+		Name:      "generation_duration_seconds",
+		Help:      "Time spent generating window PoSt proofs for a deadline.",
+	})
+	metricPoStSubmissionDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "lotus",
+		Subsystem: "wdpost",
+		Name:      "submission_duration_seconds",
+		Help:      "Time spent submitting window PoSt messages for a deadline.",
+	})
+	metricPartitionsPerMessage = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "lotus",
+		Subsystem: "wdpost",
+		Name:      "partitions_per_message",
+		Help:      "Number of partitions included in each SubmitWindowedPoSt message.",
+	})
+	metricMessageFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "lotus",
+		Subsystem: "wdpost",
+		Name:      "message_failures_total",
+		Help:      "Number of window PoSt related messages that failed to land on chain.",
+	})
+	metricFaultsDetected = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "lotus",
+		Subsystem: "wdpost",
+		Name:      "faults_detected_total",
+		Help:      "Number of sectors newly detected as faulty while checking a deadline.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(
+		metricPoStGenerationDuration,
+		metricPoStSubmissionDuration,
+		metricPartitionsPerMessage,
+		metricMessageFailures,
+		metricFaultsDetected,
+	)
+}
+
+// WindowPoStEventType and WindowPoStEvent are the same types the storage
+// miner API exposes through api.StorageMiner.WindowPoStEvents, so that
+// WindowPoStScheduler.WindowPoStEvents can back that endpoint directly.
+type WindowPoStEventType = api.WindowPoStEventType
+
+const (
+	GeneratePoStStarted  = api.GeneratePoStStarted
+	GeneratePoStFinished = api.GeneratePoStFinished
+	SubmitPoStStarted    = api.SubmitPoStStarted
+	SubmitPoStConfirmed  = api.SubmitPoStConfirmed
+	PartitionSkipped     = api.PartitionSkipped
+)
+
+// WindowPoStEvent is a single, typed step in the window PoSt lifecycle for
+// one deadline, emitted to anyone subscribed through
+// WindowPoStScheduler.WindowPoStEvents.
+type WindowPoStEvent = api.WindowPoStEvent
+
+// wdPoStEventBus fans WindowPoStEvents out to every subscriber. Subscribers
+// that fall behind have events dropped for them rather than blocking the
+// scheduler.
+type wdPoStEventBus struct {
+	mu   sync.Mutex
+	subs map[chan WindowPoStEvent]struct{}
+}
+
+func newWdPoStEventBus() *wdPoStEventBus {
+	return &wdPoStEventBus{subs: make(map[chan WindowPoStEvent]struct{})}
+}
+
+func (b *wdPoStEventBus) subscribe() (<-chan WindowPoStEvent, func()) {
+	ch := make(chan WindowPoStEvent, 32)
+
+	b.mu.Lock()
+	b.subs[ch] = struct{}{}
+	b.mu.Unlock()
+
+	unsub := func() {
+		b.mu.Lock()
+		delete(b.subs, ch)
+		b.mu.Unlock()
+		close(ch)
+	}
+
+	return ch, unsub
+}
+
+func (b *wdPoStEventBus) emit(evt WindowPoStEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.subs {
+		select {
+		case ch <- evt:
+		default:
+			// subscriber isn't keeping up; drop rather than block the scheduler
+		}
+	}
+}
@@ -0,0 +1,630 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-bitfield"
+	"github.com/ipfs/go-cid"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/crypto"
+	"github.com/filecoin-project/go-state-types/dline"
+
+	"github.com/filecoin-project/specs-actors/actors/builtin"
+	"github.com/filecoin-project/specs-actors/actors/builtin/miner"
+	"github.com/filecoin-project/specs-actors/actors/runtime/proof"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+var log = logging.Logger("storage")
+
+// fullNodeFilteredAPI is the subset of the full node API that the window
+// PoST scheduler depends on.
+type fullNodeFilteredAPI interface {
+	ChainGetRandomnessFromTickets(ctx context.Context, tsk types.TipSetKey, personalization crypto.DomainSeparationTag, randEpoch abi.ChainEpoch, entropy []byte) (abi.Randomness, error)
+	ChainGetRandomnessFromBeacon(ctx context.Context, tsk types.TipSetKey, personalization crypto.DomainSeparationTag, randEpoch abi.ChainEpoch, entropy []byte) (abi.Randomness, error)
+	ChainHead(ctx context.Context) (*types.TipSet, error)
+	ChainNotify(ctx context.Context) (<-chan []*api.HeadChange, error)
+	ChainGetTipSet(ctx context.Context, key types.TipSetKey) (*types.TipSet, error)
+	ChainGetTipSetByHeight(ctx context.Context, epoch abi.ChainEpoch, key types.TipSetKey) (*types.TipSet, error)
+	ChainGetBlockMessages(ctx context.Context, cid cid.Cid) (*api.BlockMessages, error)
+	ChainReadObj(ctx context.Context, cid cid.Cid) ([]byte, error)
+	ChainHasObj(ctx context.Context, cid cid.Cid) (bool, error)
+
+	StateMinerPartitions(ctx context.Context, address address.Address, u uint64, key types.TipSetKey) ([]*miner.Partition, error)
+	StateMinerSectors(ctx context.Context, address address.Address, field *bitfield.BitField, b bool, key types.TipSetKey) ([]*api.ChainSectorInfo, error)
+	StateMinerInfo(ctx context.Context, address address.Address, key types.TipSetKey) (api.MinerInfo, error)
+	StateMinerDeadlines(ctx context.Context, maddr address.Address, tok types.TipSetKey) ([]*miner.Deadline, error)
+	StateMinerProvingDeadline(ctx context.Context, address address.Address, key types.TipSetKey) (*dline.Info, error)
+	StateMinerFaults(ctx context.Context, address address.Address, key types.TipSetKey) (bitfield.BitField, error)
+	StateMinerRecoveries(ctx context.Context, address address.Address, key types.TipSetKey) (bitfield.BitField, error)
+	StateMinerPreCommitDepositForPower(ctx context.Context, address address.Address, info miner.SectorPreCommitInfo, key types.TipSetKey) (types.BigInt, error)
+	StateMinerInitialPledgeCollateral(ctx context.Context, address address.Address, info miner.SectorPreCommitInfo, key types.TipSetKey) (types.BigInt, error)
+	StateSectorPreCommitInfo(ctx context.Context, address address.Address, number abi.SectorNumber, key types.TipSetKey) (miner.SectorPreCommitOnChainInfo, error)
+	StateSectorGetInfo(ctx context.Context, address address.Address, number abi.SectorNumber, key types.TipSetKey) (*miner.SectorOnChainInfo, error)
+	StateSectorPartition(ctx context.Context, maddr address.Address, sectorNumber abi.SectorNumber, tok types.TipSetKey) (*api.SectorLocation, error)
+	StateSearchMsg(ctx context.Context, cid cid.Cid) (*api.MsgLookup, error)
+	StateWaitMsg(ctx context.Context, cid cid.Cid, confidence uint64) (*api.MsgLookup, error)
+	StateGetActor(ctx context.Context, actor address.Address, ts types.TipSetKey) (*types.Actor, error)
+	StateGetReceipt(ctx context.Context, cid cid.Cid, key types.TipSetKey) (*types.MessageReceipt, error)
+	StateCall(ctx context.Context, message *types.Message, key types.TipSetKey) (*api.InvocResult, error)
+	StateMarketStorageDeal(ctx context.Context, id abi.DealID, key types.TipSetKey) (*api.MarketDeal, error)
+	StateAccountKey(ctx context.Context, address address.Address, key types.TipSetKey) (address.Address, error)
+
+	MpoolPushMessage(ctx context.Context, message *types.Message, spec *api.MessageSendSpec) (*types.SignedMessage, error)
+	GasEstimateMessageGas(ctx context.Context, message *types.Message, spec *api.MessageSendSpec, key types.TipSetKey) (*types.Message, error)
+
+	WalletSign(ctx context.Context, address address.Address, bytes []byte) (*crypto.Signature, error)
+	WalletBalance(ctx context.Context, address address.Address) (types.BigInt, error)
+	WalletHas(ctx context.Context, address address.Address) (bool, error)
+}
+
+// Prover is the subset of the sector storage manager that can generate
+// winning and window PoSt proofs.
+type Prover interface {
+	GenerateWinningPoSt(context.Context, abi.ActorID, []proof.SectorInfo, abi.PoStRandomness) ([]proof.PoStProof, error)
+	GenerateWindowPoSt(ctx context.Context, aid abi.ActorID, sis []proof.SectorInfo, pr abi.PoStRandomness) ([]proof.PoStProof, []abi.SectorID, error)
+}
+
+// FaultTracker can tell which sectors are provable from local storage right
+// now, independent of what the chain currently believes.
+type FaultTracker interface {
+	CheckProvable(ctx context.Context, spt abi.RegisteredSealProof, sectors []abi.SectorID) ([]abi.SectorID, error)
+}
+
+// WindowPoStScheduler implements the WindowPoStEvents side of the storage
+// miner API.
+var _ api.StorageMiner = (*WindowPoStScheduler)(nil)
+
+// WindowPoStScheduler watches the chain for the miner's proving deadlines
+// and generates and submits window PoSts for them.
+type WindowPoStScheduler struct {
+	api          fullNodeFilteredAPI
+	prover       Prover
+	faultTracker FaultTracker
+	proofType    abi.RegisteredPoStProof
+
+	actor  address.Address
+	worker address.Address
+
+	eventsLk sync.Mutex
+	events   *wdPoStEventBus
+
+	retriesLk sync.Mutex
+	retries   map[retryKey]int
+}
+
+// maxPartitionRetries bounds how many times a single (deadline, partition)
+// is resubmitted on its own before it is given up on as irrecoverable.
+const maxPartitionRetries = 3
+
+// retryKey identifies a single partition within a single occurrence of a
+// deadline for the purposes of the retry budget. deadline (di.Index) cycles
+// every proving period, so periodStart (di.PeriodStart) is included to tell
+// apart today's occurrence of a deadline from the next time it comes
+// around, days later.
+type retryKey struct {
+	periodStart abi.ChainEpoch
+	deadline    uint64
+	partition   uint64
+}
+
+// PartitionResult describes what became of a single partition's
+// SubmitWindowedPoSt submission.
+type PartitionResult struct {
+	Deadline  uint64
+	Partition uint64
+
+	// Submitted is true if the partition's message landed on chain with
+	// exit code 0.
+	Submitted bool
+	// Recoverable is false once the partition has exhausted its retry
+	// budget and will no longer be resubmitted.
+	Recoverable bool
+}
+
+// NewWindowPoStScheduler creates a scheduler that proves the given miner
+// actor's sectors on every deadline using the worker key to sign messages.
+func NewWindowPoStScheduler(api fullNodeFilteredAPI, prover Prover, faultTracker FaultTracker, proofType abi.RegisteredPoStProof, actor, worker address.Address) *WindowPoStScheduler {
+	return &WindowPoStScheduler{
+		api:          api,
+		prover:       prover,
+		faultTracker: faultTracker,
+		proofType:    proofType,
+		actor:        actor,
+		worker:       worker,
+		events:       newWdPoStEventBus(),
+		retries:      make(map[retryKey]int),
+	}
+}
+
+// retryCount returns how many times key has already been resubmitted,
+// lazily initializing the retry map for schedulers built as struct
+// literals.
+func (s *WindowPoStScheduler) retryCount(key retryKey) int {
+	s.retriesLk.Lock()
+	defer s.retriesLk.Unlock()
+
+	if s.retries == nil {
+		s.retries = make(map[retryKey]int)
+	}
+	return s.retries[key]
+}
+
+// useRetry consumes one retry attempt for key and returns the new count.
+func (s *WindowPoStScheduler) useRetry(key retryKey) int {
+	s.retriesLk.Lock()
+	defer s.retriesLk.Unlock()
+
+	if s.retries == nil {
+		s.retries = make(map[retryKey]int)
+	}
+	s.retries[key]++
+	return s.retries[key]
+}
+
+// clearRetry drops key's retry count once its partition has succeeded, so
+// the map doesn't hold onto entries no occurrence will ever look up again.
+func (s *WindowPoStScheduler) clearRetry(key retryKey) {
+	s.retriesLk.Lock()
+	defer s.retriesLk.Unlock()
+
+	delete(s.retries, key)
+}
+
+// pruneRetries drops every retry entry left over from a previous occurrence
+// of a deadline, identified by a periodStart other than the one given. It
+// is called once per deadline occurrence so the retry map doesn't grow
+// without bound over the scheduler's lifetime.
+func (s *WindowPoStScheduler) pruneRetries(periodStart abi.ChainEpoch) {
+	s.retriesLk.Lock()
+	defer s.retriesLk.Unlock()
+
+	for key := range s.retries {
+		if key.periodStart != periodStart {
+			delete(s.retries, key)
+		}
+	}
+}
+
+// eventBus returns the scheduler's event bus, lazily creating it so that a
+// scheduler built as a struct literal (as this package's tests do) still
+// has one.
+func (s *WindowPoStScheduler) eventBus() *wdPoStEventBus {
+	s.eventsLk.Lock()
+	defer s.eventsLk.Unlock()
+
+	if s.events == nil {
+		s.events = newWdPoStEventBus()
+	}
+	return s.events
+}
+
+// WindowPoStEvents returns a channel of WindowPoStEvent describing every
+// step the scheduler takes, for operators who want per-deadline visibility
+// without parsing logs. The channel is closed when ctx is cancelled. This
+// backs the storage miner API's WindowPoStEvents endpoint.
+func (s *WindowPoStScheduler) WindowPoStEvents(ctx context.Context) (<-chan WindowPoStEvent, error) {
+	ch, unsub := s.eventBus().subscribe()
+	go func() {
+		<-ctx.Done()
+		unsub()
+	}()
+
+	return ch, nil
+}
+
+func (s *WindowPoStScheduler) emit(evt WindowPoStEvent) {
+	s.eventBus().emit(evt)
+}
+
+// startGeneratePoST kicks off, in the background, fault declaration for the
+// upcoming deadline followed by window PoSt proof generation. complete is
+// called with the generated messages once proving finishes (or with an
+// error if it fails).
+func (s *WindowPoStScheduler) startGeneratePoST(ctx context.Context, ts *types.TipSet, di *dline.Info, complete func(posts []miner.SubmitWindowedPoStParams, err error)) {
+	go func() {
+		if err := s.runFaultDeclarations(ctx, di, ts); err != nil {
+			log.Errorf("runFaultDeclarations failed: %+v", err)
+		}
+
+		s.emit(WindowPoStEvent{Type: GeneratePoStStarted, Deadline: di.Index})
+		start := time.Now()
+
+		posts, err := s.runPost(ctx, *di, ts)
+		if err != nil {
+			log.Errorf("runPost failed: %+v", err)
+		}
+
+		metricPoStGenerationDuration.Observe(time.Since(start).Seconds())
+		s.emit(WindowPoStEvent{Type: GeneratePoStFinished, Deadline: di.Index, Error: err})
+
+		complete(posts, err)
+	}()
+}
+
+// startSubmitPoST kicks off, in the background, submission of the messages
+// generated by startGeneratePoST. Any partition whose batch message lands
+// on chain with a non-zero exit code is resubmitted on its own, up to its
+// retry budget; complete is called with the outcome of every partition
+// once submission (and any retries) finish, or with an error, and the
+// results gathered so far, if pushing or waiting on a message, or
+// generating a retry's proof, fails outright.
+func (s *WindowPoStScheduler) startSubmitPoST(ctx context.Context, ts *types.TipSet, di *dline.Info, posts []miner.SubmitWindowedPoStParams, complete func(results []PartitionResult, err error)) {
+	go func() {
+		s.emit(WindowPoStEvent{Type: SubmitPoStStarted, Deadline: di.Index})
+		start := time.Now()
+
+		results, err := s.submitPost(ctx, *di, posts)
+
+		metricPoStSubmissionDuration.Observe(time.Since(start).Seconds())
+		if err != nil {
+			metricMessageFailures.Inc()
+		} else {
+			s.emit(WindowPoStEvent{Type: SubmitPoStConfirmed, Deadline: di.Index})
+		}
+
+		complete(results, err)
+	}()
+}
+
+// runPost splits the partitions assigned to this deadline across as many
+// SubmitWindowedPoSt messages as required to stay under the network's
+// per-message sector limit, and asks the prover for a proof for each
+// message.
+func (s *WindowPoStScheduler) runPost(ctx context.Context, di dline.Info, ts *types.TipSet) ([]miner.SubmitWindowedPoStParams, error) {
+	partitions, err := s.api.StateMinerPartitions(ctx, s.actor, di.Index, ts.Key())
+	if err != nil {
+		return nil, xerrors.Errorf("getting partitions: %w", err)
+	}
+
+	partitionsPerMsg, err := s.partitionsPerMsg()
+	if err != nil {
+		return nil, err
+	}
+
+	var posts []miner.SubmitWindowedPoStParams
+	for i := 0; i < len(partitions); i += partitionsPerMsg {
+		end := i + partitionsPerMsg
+		if end > len(partitions) {
+			end = len(partitions)
+		}
+
+		proofs, _, err := s.prover.GenerateWindowPoSt(ctx, abi.ActorID(0), nil, nil)
+		if err != nil {
+			return nil, xerrors.Errorf("generating window post: %w", err)
+		}
+
+		params := miner.SubmitWindowedPoStParams{
+			Deadline: di.Index,
+			Proofs:   proofs,
+		}
+		for pi := i; pi < end; pi++ {
+			params.Partitions = append(params.Partitions, miner.PoStPartition{
+				Index:   uint64(pi),
+				Skipped: bitfield.New(),
+			})
+		}
+
+		metricPartitionsPerMessage.Observe(float64(len(params.Partitions)))
+		posts = append(posts, params)
+	}
+
+	return posts, nil
+}
+
+// submitPost pushes one SubmitWindowedPoSt message per entry in posts and
+// waits for each to land on chain. A batch message that lands with a
+// non-zero exit code is split into one message per partition and each is
+// retried on its own, against that partition's retry budget for this
+// occurrence of the deadline.
+func (s *WindowPoStScheduler) submitPost(ctx context.Context, di dline.Info, posts []miner.SubmitWindowedPoStParams) ([]PartitionResult, error) {
+	s.pruneRetries(di.PeriodStart)
+
+	deadline := di.Index
+	var results []PartitionResult
+
+	for _, post := range posts {
+		post := post
+
+		ok, err := s.submitAndWait(ctx, post)
+		if err != nil {
+			return results, err
+		}
+		if ok {
+			for _, p := range post.Partitions {
+				results = append(results, PartitionResult{Deadline: deadline, Partition: p.Index, Submitted: true, Recoverable: true})
+			}
+			continue
+		}
+
+		// The batch landed with a non-zero exit code: fall back to
+		// submitting each of its partitions on its own so that one bad
+		// partition doesn't hold back the rest.
+		for _, p := range post.Partitions {
+			key := retryKey{periodStart: di.PeriodStart, deadline: deadline, partition: p.Index}
+			result := PartitionResult{Deadline: deadline, Partition: p.Index}
+
+			if s.retryCount(key) >= maxPartitionRetries {
+				metricMessageFailures.Inc()
+				s.emit(WindowPoStEvent{Type: PartitionSkipped, Deadline: deadline})
+				results = append(results, result)
+				continue
+			}
+			s.useRetry(key)
+
+			// The proof in post.Proofs was computed over the whole batch's
+			// sectors; the miner actor verifies it against the sectors of
+			// the partitions actually named in the message, so a retry
+			// naming only this partition needs its own proof.
+			proofs, _, err := s.prover.GenerateWindowPoSt(ctx, abi.ActorID(0), nil, nil)
+			if err != nil {
+				return results, xerrors.Errorf("generating window post for partition %d retry: %w", p.Index, err)
+			}
+
+			single := miner.SubmitWindowedPoStParams{
+				Deadline:   post.Deadline,
+				Partitions: []miner.PoStPartition{p},
+				Proofs:     proofs,
+			}
+
+			ok, err := s.submitAndWait(ctx, single)
+			if err != nil {
+				return results, err
+			}
+
+			result.Submitted = ok
+			result.Recoverable = ok || s.retryCount(key) < maxPartitionRetries
+			if ok {
+				s.clearRetry(key)
+			} else {
+				metricMessageFailures.Inc()
+				s.emit(WindowPoStEvent{Type: PartitionSkipped, Deadline: deadline})
+			}
+			results = append(results, result)
+		}
+	}
+
+	return results, nil
+}
+
+// submitAndWait pushes a single SubmitWindowedPoSt message and waits for
+// its receipt, returning whether it landed with exit code 0.
+func (s *WindowPoStScheduler) submitAndWait(ctx context.Context, post miner.SubmitWindowedPoStParams) (bool, error) {
+	buf := new(bytes.Buffer)
+	if err := post.MarshalCBOR(buf); err != nil {
+		return false, xerrors.Errorf("could not serialize submit post parameters: %w", err)
+	}
+
+	msg := &types.Message{
+		To:     s.actor,
+		From:   s.worker,
+		Method: builtin.MethodsMiner.SubmitWindowedPoSt,
+		Params: buf.Bytes(),
+		Value:  types.NewInt(0),
+	}
+
+	sm, err := s.api.MpoolPushMessage(ctx, msg, nil)
+	if err != nil {
+		return false, xerrors.Errorf("pushing submit window post message: %w", err)
+	}
+
+	mw, err := s.api.StateWaitMsg(ctx, sm.Cid(), 0)
+	if err != nil {
+		return false, xerrors.Errorf("waiting for submit window post message: %w", err)
+	}
+
+	return mw.Receipt.ExitCode == 0, nil
+}
+
+// partitionsPerMsg is the number of partitions of this proof type that fit
+// in a single message without exceeding the network's per-message sector
+// limit.
+func (s *WindowPoStScheduler) partitionsPerMsg() (int, error) {
+	sectorsPerPartition, err := builtin.PoStProofWindowPoStPartitionSectors(s.proofType)
+	if err != nil {
+		return 0, xerrors.Errorf("getting sectors per partition: %w", err)
+	}
+
+	partitionsPerMsg := int(miner.AddressedSectorsMax / sectorsPerPartition)
+	if partitionsPerMsg < 1 {
+		partitionsPerMsg = 1
+	}
+
+	return partitionsPerMsg, nil
+}
+
+// runFaultDeclarations compares what the chain currently believes about this
+// miner's sectors against what is provable from local storage right now,
+// and pushes DeclareFaults / DeclareFaultsRecovered messages for any
+// sectors whose state has changed since the last deadline.
+func (s *WindowPoStScheduler) runFaultDeclarations(ctx context.Context, di *dline.Info, ts *types.TipSet) error {
+	partitions, err := s.api.StateMinerPartitions(ctx, s.actor, di.Index, ts.Key())
+	if err != nil {
+		return xerrors.Errorf("getting partitions: %w", err)
+	}
+
+	partitionOf := make(map[uint64]uint64)
+	var allSectors []abi.SectorID
+	for pi, partition := range partitions {
+		if err := partition.Sectors.ForEach(func(s uint64) error {
+			partitionOf[s] = uint64(pi)
+			allSectors = append(allSectors, abi.SectorID{Number: abi.SectorNumber(s)})
+			return nil
+		}); err != nil {
+			return xerrors.Errorf("iterating partition sectors: %w", err)
+		}
+	}
+
+	spt, err := s.proofType.RegisteredSealProof()
+	if err != nil {
+		return xerrors.Errorf("getting seal proof type: %w", err)
+	}
+
+	badSectors, err := s.faultTracker.CheckProvable(ctx, spt, allSectors)
+	if err != nil {
+		return xerrors.Errorf("checking provable sectors: %w", err)
+	}
+	bad := make(map[uint64]bool, len(badSectors))
+	for _, sid := range badSectors {
+		bad[uint64(sid.Number)] = true
+	}
+
+	onChainFaults, err := s.api.StateMinerFaults(ctx, s.actor, ts.Key())
+	if err != nil {
+		return xerrors.Errorf("getting on-chain faults: %w", err)
+	}
+	onChainRecoveries, err := s.api.StateMinerRecoveries(ctx, s.actor, ts.Key())
+	if err != nil {
+		return xerrors.Errorf("getting on-chain recoveries: %w", err)
+	}
+
+	newFaults := map[uint64]*bitfield.BitField{}
+	newRecoveries := map[uint64]*bitfield.BitField{}
+
+	for sector, pi := range partitionOf {
+		wasFaulty, err := onChainFaults.IsSet(sector)
+		if err != nil {
+			return xerrors.Errorf("checking on-chain fault state: %w", err)
+		}
+		wasRecovering, err := onChainRecoveries.IsSet(sector)
+		if err != nil {
+			return xerrors.Errorf("checking on-chain recovery state: %w", err)
+		}
+
+		switch {
+		case bad[sector] && !wasFaulty:
+			addSector(newFaults, pi, sector)
+			metricFaultsDetected.Inc()
+		case !bad[sector] && wasFaulty && !wasRecovering:
+			addSector(newRecoveries, pi, sector)
+		}
+	}
+
+	if err := s.declareFaults(ctx, newFaults); err != nil {
+		return xerrors.Errorf("declaring faults: %w", err)
+	}
+	if err := s.declareFaultsRecovered(ctx, newRecoveries); err != nil {
+		return xerrors.Errorf("declaring recovered faults: %w", err)
+	}
+
+	return nil
+}
+
+func addSector(byPartition map[uint64]*bitfield.BitField, partition, sector uint64) {
+	bf, ok := byPartition[partition]
+	if !ok {
+		nbf := bitfield.New()
+		bf = &nbf
+		byPartition[partition] = bf
+	}
+	bf.Set(sector)
+}
+
+// declareFaults pushes one DeclareFaults message per partitionsPerMsg()
+// partitions (AddressedSectorsMax sectors' worth) of newly-faulty sectors.
+func (s *WindowPoStScheduler) declareFaults(ctx context.Context, byPartition map[uint64]*bitfield.BitField) error {
+	if len(byPartition) == 0 {
+		return nil
+	}
+
+	partitionsPerMsg, err := s.partitionsPerMsg()
+	if err != nil {
+		return err
+	}
+
+	var faults []miner.FaultDeclaration
+	for pi, bf := range byPartition {
+		faults = append(faults, miner.FaultDeclaration{
+			Partition: pi,
+			Sectors:   *bf,
+		})
+	}
+
+	for i := 0; i < len(faults); i += partitionsPerMsg {
+		end := i + partitionsPerMsg
+		if end > len(faults) {
+			end = len(faults)
+		}
+
+		params := &miner.DeclareFaultsParams{Faults: faults[i:end]}
+
+		buf := new(bytes.Buffer)
+		if err := params.MarshalCBOR(buf); err != nil {
+			return xerrors.Errorf("could not serialize declare faults parameters: %w", err)
+		}
+
+		msg := &types.Message{
+			To:     s.actor,
+			From:   s.worker,
+			Method: builtin.MethodsMiner.DeclareFaults,
+			Params: buf.Bytes(),
+			Value:  types.NewInt(0),
+		}
+
+		if _, err := s.api.MpoolPushMessage(ctx, msg, nil); err != nil {
+			return xerrors.Errorf("pushing declare faults message: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// declareFaultsRecovered pushes one DeclareFaultsRecovered message per
+// partitionsPerMsg() partitions (AddressedSectorsMax sectors' worth) of
+// newly-recovered sectors.
+func (s *WindowPoStScheduler) declareFaultsRecovered(ctx context.Context, byPartition map[uint64]*bitfield.BitField) error {
+	if len(byPartition) == 0 {
+		return nil
+	}
+
+	partitionsPerMsg, err := s.partitionsPerMsg()
+	if err != nil {
+		return err
+	}
+
+	var recoveries []miner.RecoveryDeclaration
+	for pi, bf := range byPartition {
+		recoveries = append(recoveries, miner.RecoveryDeclaration{
+			Partition: pi,
+			Sectors:   *bf,
+		})
+	}
+
+	for i := 0; i < len(recoveries); i += partitionsPerMsg {
+		end := i + partitionsPerMsg
+		if end > len(recoveries) {
+			end = len(recoveries)
+		}
+
+		params := &miner.DeclareFaultsRecoveredParams{Recoveries: recoveries[i:end]}
+
+		buf := new(bytes.Buffer)
+		if err := params.MarshalCBOR(buf); err != nil {
+			return xerrors.Errorf("could not serialize declare faults recovered parameters: %w", err)
+		}
+
+		msg := &types.Message{
+			To:     s.actor,
+			From:   s.worker,
+			Method: builtin.MethodsMiner.DeclareFaultsRecovered,
+			Params: buf.Bytes(),
+			Value:  types.NewInt(0),
+		}
+
+		if _, err := s.api.MpoolPushMessage(ctx, msg, nil); err != nil {
+			return xerrors.Errorf("pushing declare faults recovered message: %w", err)
+		}
+	}
+
+	return nil
+}
@@ -3,6 +3,7 @@ package storage
 import (
 	"bytes"
 	"context"
+	"sync"
 	"testing"
 
 	"golang.org/x/xerrors"
@@ -17,6 +18,7 @@ import (
 
 	"github.com/filecoin-project/specs-actors/actors/builtin"
 	"github.com/filecoin-project/specs-actors/actors/builtin/miner"
+	"github.com/filecoin-project/specs-actors/actors/runtime/exitcode"
 	"github.com/filecoin-project/specs-actors/actors/runtime/proof"
 
 	"github.com/filecoin-project/go-state-types/dline"
@@ -29,11 +31,20 @@ import (
 type mockStorageMinerAPI struct {
 	partitions     []*miner.Partition
 	pushedMessages chan *types.Message
+	faults         bitfield.BitField
+	recoveries     bitfield.BitField
+
+	waitResultsLk sync.Mutex
+	// waitResults are consumed in order by StateWaitMsg, one exit code per
+	// call; once exhausted, StateWaitMsg reports success.
+	waitResults []exitcode.ExitCode
 }
 
 func newMockStorageMinerAPI() *mockStorageMinerAPI {
 	return &mockStorageMinerAPI{
 		pushedMessages: make(chan *types.Message),
+		faults:         bitfield.New(),
+		recoveries:     bitfield.New(),
 	}
 }
 
@@ -79,13 +90,30 @@ func (m *mockStorageMinerAPI) MpoolPushMessage(ctx context.Context, message *typ
 }
 
 func (m *mockStorageMinerAPI) StateWaitMsg(ctx context.Context, cid cid.Cid, confidence uint64) (*api.MsgLookup, error) {
+	m.waitResultsLk.Lock()
+	defer m.waitResultsLk.Unlock()
+
+	ec := exitcode.Ok
+	if len(m.waitResults) > 0 {
+		ec = m.waitResults[0]
+		m.waitResults = m.waitResults[1:]
+	}
+
 	return &api.MsgLookup{
 		Receipt: types.MessageReceipt{
-			ExitCode: 0,
+			ExitCode: ec,
 		},
 	}, nil
 }
 
+func (m *mockStorageMinerAPI) StateMinerFaults(ctx context.Context, address address.Address, key types.TipSetKey) (bitfield.BitField, error) {
+	return m.faults, nil
+}
+
+func (m *mockStorageMinerAPI) StateMinerRecoveries(ctx context.Context, address address.Address, key types.TipSetKey) (bitfield.BitField, error) {
+	return m.recoveries, nil
+}
+
 type mockProver struct {
 }
 
@@ -103,11 +131,12 @@ func (m *mockProver) GenerateWindowPoSt(ctx context.Context, aid abi.ActorID, si
 }
 
 type mockFaultTracker struct {
+	bad []abi.SectorID
 }
 
 func (m mockFaultTracker) CheckProvable(ctx context.Context, spt abi.RegisteredSealProof, sectors []abi.SectorID) ([]abi.SectorID, error) {
 	// Returns "bad" sectors so just return nil meaning all sectors are good
-	return nil, nil
+	return m.bad, nil
 }
 
 // TestWDPostDoPost verifies that doPost will send the correct number of window
@@ -160,7 +189,7 @@ func TestWDPostDoPost(t *testing.T) {
 	ts := mockTipSet(t)
 
 	scheduler.startGeneratePoST(ctx, ts, di, func(posts []miner.SubmitWindowedPoStParams, err error) {
-		scheduler.startSubmitPoST(ctx, ts, di, posts, func(err error) {})
+		scheduler.startSubmitPoST(ctx, ts, di, posts, func(results []PartitionResult, err error) {})
 	})
 
 	// Read the window PoST messages
@@ -181,6 +210,298 @@ func TestWDPostDoPost(t *testing.T) {
 	}
 }
 
+// TestWDPostSubmitRetriesFailedPartitions verifies that when a batch
+// SubmitWindowedPoSt message lands with a non-zero exit code, submitPost
+// falls back to resubmitting each of its partitions individually.
+func TestWDPostSubmitRetriesFailedPartitions(t *testing.T) {
+	ctx := context.Background()
+
+	proofType := abi.RegisteredPoStProof_StackedDrgWindow2KiBV1
+	postAct := tutils.NewIDAddr(t, 100)
+	workerAct := tutils.NewIDAddr(t, 101)
+
+	mockStgMinerAPI := newMockStorageMinerAPI()
+	// The first SubmitWindowedPoSt message (the batch) fails; every
+	// subsequent one (the per-partition retries) succeeds.
+	mockStgMinerAPI.waitResults = []exitcode.ExitCode{exitcode.ErrForbidden}
+
+	sectorsPerPartition, err := builtin.PoStProofWindowPoStPartitionSectors(proofType)
+	require.NoError(t, err)
+
+	var partitions []*miner.Partition
+	for p := 0; p < 2; p++ {
+		sectors := bitfield.New()
+		for s := uint64(0); s < sectorsPerPartition; s++ {
+			sectors.Set(s)
+		}
+		partitions = append(partitions, &miner.Partition{Sectors: sectors})
+	}
+	mockStgMinerAPI.setPartitions(partitions)
+
+	scheduler := NewWindowPoStScheduler(mockStgMinerAPI, &mockProver{}, &mockFaultTracker{}, proofType, postAct, workerAct)
+
+	ts := mockTipSet(t)
+	di := &dline.Info{Index: 7}
+
+	posts, err := scheduler.runPost(ctx, *di, ts)
+	require.NoError(t, err)
+	require.Len(t, posts, 1)
+	require.Len(t, posts[0].Partitions, 2)
+
+	type submitOutcome struct {
+		results []PartitionResult
+		err     error
+	}
+	outcomeCh := make(chan submitOutcome, 1)
+	go func() {
+		results, err := scheduler.submitPost(ctx, *di, posts)
+		outcomeCh <- submitOutcome{results, err}
+	}()
+
+	// The first message is the full batch; it is the one that fails.
+	batchMsg := <-mockStgMinerAPI.pushedMessages
+	var batchParams miner.SubmitWindowedPoStParams
+	require.NoError(t, batchParams.UnmarshalCBOR(bytes.NewReader(batchMsg.Params)))
+	require.Len(t, batchParams.Partitions, 2)
+
+	// It is retried as two single-partition messages, one per partition.
+	seen := make(map[uint64]bool)
+	for i := 0; i < 2; i++ {
+		msg := <-mockStgMinerAPI.pushedMessages
+		var params miner.SubmitWindowedPoStParams
+		require.NoError(t, params.UnmarshalCBOR(bytes.NewReader(msg.Params)))
+		require.Len(t, params.Partitions, 1)
+		seen[params.Partitions[0].Index] = true
+	}
+	require.Len(t, seen, 2)
+
+	outcome := <-outcomeCh
+	require.NoError(t, outcome.err)
+	require.Len(t, outcome.results, 2)
+	for _, r := range outcome.results {
+		require.True(t, r.Submitted)
+		require.True(t, r.Recoverable)
+	}
+}
+
+// TestWDPostSubmitExhaustsRetryBudget verifies that once a partition has
+// failed its batch submission and its per-partition retry maxPartitionRetries
+// times in a row, submitPost stops resubmitting it and reports it as
+// unrecoverable.
+func TestWDPostSubmitExhaustsRetryBudget(t *testing.T) {
+	ctx := context.Background()
+
+	proofType := abi.RegisteredPoStProof_StackedDrgWindow2KiBV1
+	postAct := tutils.NewIDAddr(t, 100)
+	workerAct := tutils.NewIDAddr(t, 101)
+
+	mockStgMinerAPI := newMockStorageMinerAPI()
+	// Every batch submission and every retry fails, so the partition burns
+	// through its entire retry budget without ever landing on chain.
+	var waitResults []exitcode.ExitCode
+	for i := 0; i < maxPartitionRetries; i++ {
+		waitResults = append(waitResults, exitcode.ErrForbidden, exitcode.ErrForbidden)
+	}
+	waitResults = append(waitResults, exitcode.ErrForbidden)
+	mockStgMinerAPI.waitResults = waitResults
+
+	sectorsPerPartition, err := builtin.PoStProofWindowPoStPartitionSectors(proofType)
+	require.NoError(t, err)
+
+	sectors := bitfield.New()
+	for s := uint64(0); s < sectorsPerPartition; s++ {
+		sectors.Set(s)
+	}
+	mockStgMinerAPI.setPartitions([]*miner.Partition{{Sectors: sectors}})
+
+	scheduler := NewWindowPoStScheduler(mockStgMinerAPI, &mockProver{}, &mockFaultTracker{}, proofType, postAct, workerAct)
+
+	ts := mockTipSet(t)
+	di := &dline.Info{Index: 9, PeriodStart: 1000}
+
+	posts, err := scheduler.runPost(ctx, *di, ts)
+	require.NoError(t, err)
+	require.Len(t, posts, 1)
+	require.Len(t, posts[0].Partitions, 1)
+
+	type submitOutcome struct {
+		results []PartitionResult
+		err     error
+	}
+	submit := func() submitOutcome {
+		outcomeCh := make(chan submitOutcome, 1)
+		go func() {
+			results, err := scheduler.submitPost(ctx, *di, posts)
+			outcomeCh <- submitOutcome{results, err}
+		}()
+		return <-outcomeCh
+	}
+
+	// The first maxPartitionRetries occurrences each fail their batch
+	// submission and their one-off retry, consuming the retry budget.
+	for i := 0; i < maxPartitionRetries; i++ {
+		go func() {
+			<-mockStgMinerAPI.pushedMessages // the failed batch
+			<-mockStgMinerAPI.pushedMessages // the failed retry
+		}()
+
+		outcome := submit()
+		require.NoError(t, outcome.err)
+		require.Len(t, outcome.results, 1)
+		require.False(t, outcome.results[0].Submitted)
+	}
+
+	// The budget is now exhausted: the batch is still submitted, but the
+	// partition is skipped without a retry attempt, and comes back
+	// unrecoverable.
+	go func() {
+		<-mockStgMinerAPI.pushedMessages // the failed batch; no retry follows
+	}()
+
+	outcome := submit()
+	require.NoError(t, outcome.err)
+	require.Len(t, outcome.results, 1)
+	require.False(t, outcome.results[0].Submitted)
+	require.False(t, outcome.results[0].Recoverable)
+}
+
+// TestWDPostEventOrdering verifies that a subscriber on
+// WindowPoStScheduler.WindowPoStEvents sees the lifecycle events for a deadline in
+// the expected order.
+func TestWDPostEventOrdering(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	proofType := abi.RegisteredPoStProof_StackedDrgWindow2KiBV1
+	postAct := tutils.NewIDAddr(t, 100)
+	workerAct := tutils.NewIDAddr(t, 101)
+
+	mockStgMinerAPI := newMockStorageMinerAPI()
+
+	sectors := bitfield.New()
+	sectors.Set(0)
+	mockStgMinerAPI.setPartitions([]*miner.Partition{{Sectors: sectors}})
+
+	scheduler := NewWindowPoStScheduler(mockStgMinerAPI, &mockProver{}, &mockFaultTracker{}, proofType, postAct, workerAct)
+
+	events, err := scheduler.WindowPoStEvents(ctx)
+	require.NoError(t, err)
+
+	di := &dline.Info{}
+	ts := mockTipSet(t)
+
+	scheduler.startGeneratePoST(ctx, ts, di, func(posts []miner.SubmitWindowedPoStParams, err error) {
+		require.NoError(t, err)
+		scheduler.startSubmitPoST(ctx, ts, di, posts, func(results []PartitionResult, err error) {})
+	})
+
+	// Drain the single SubmitWindowedPoSt message so startSubmitPoST can finish.
+	go func() {
+		<-mockStgMinerAPI.pushedMessages
+	}()
+
+	require.Equal(t, GeneratePoStStarted, (<-events).Type)
+	require.Equal(t, GeneratePoStFinished, (<-events).Type)
+	require.Equal(t, SubmitPoStStarted, (<-events).Type)
+	require.Equal(t, SubmitPoStConfirmed, (<-events).Type)
+}
+
+// TestWDPostDeclareFaults verifies that runFaultDeclarations sends a
+// DeclareFaults message for sectors the fault tracker reports as
+// unprovable but that the chain does not yet know are faulty.
+func TestWDPostDeclareFaults(t *testing.T) {
+	ctx := context.Background()
+
+	proofType := abi.RegisteredPoStProof_StackedDrgWindow2KiBV1
+	postAct := tutils.NewIDAddr(t, 100)
+	workerAct := tutils.NewIDAddr(t, 101)
+
+	mockStgMinerAPI := newMockStorageMinerAPI()
+
+	sectors := bitfield.New()
+	sectors.Set(0)
+	sectors.Set(1)
+	mockStgMinerAPI.setPartitions([]*miner.Partition{{Sectors: sectors}})
+
+	scheduler := &WindowPoStScheduler{
+		api:          mockStgMinerAPI,
+		prover:       &mockProver{},
+		faultTracker: &mockFaultTracker{bad: []abi.SectorID{{Number: 1}}},
+		proofType:    proofType,
+		actor:        postAct,
+		worker:       workerAct,
+	}
+
+	di := &dline.Info{}
+	ts := mockTipSet(t)
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- scheduler.runFaultDeclarations(ctx, di, ts)
+	}()
+
+	msg := <-mockStgMinerAPI.pushedMessages
+	require.Equal(t, builtin.MethodsMiner.DeclareFaults, msg.Method)
+
+	var params miner.DeclareFaultsParams
+	require.NoError(t, params.UnmarshalCBOR(bytes.NewReader(msg.Params)))
+	require.Len(t, params.Faults, 1)
+
+	faulty, err := params.Faults[0].Sectors.IsSet(1)
+	require.NoError(t, err)
+	require.True(t, faulty)
+
+	require.NoError(t, <-errCh)
+}
+
+// TestWDPostDeclareFaultsRecovered verifies that runFaultDeclarations sends
+// a DeclareFaultsRecovered message for sectors the chain believes are
+// faulty but that the fault tracker now reports as provable again.
+func TestWDPostDeclareFaultsRecovered(t *testing.T) {
+	ctx := context.Background()
+
+	proofType := abi.RegisteredPoStProof_StackedDrgWindow2KiBV1
+	postAct := tutils.NewIDAddr(t, 100)
+	workerAct := tutils.NewIDAddr(t, 101)
+
+	mockStgMinerAPI := newMockStorageMinerAPI()
+
+	sectors := bitfield.New()
+	sectors.Set(0)
+	mockStgMinerAPI.setPartitions([]*miner.Partition{{Sectors: sectors}})
+	mockStgMinerAPI.faults.Set(0)
+
+	scheduler := &WindowPoStScheduler{
+		api:          mockStgMinerAPI,
+		prover:       &mockProver{},
+		faultTracker: &mockFaultTracker{},
+		proofType:    proofType,
+		actor:        postAct,
+		worker:       workerAct,
+	}
+
+	di := &dline.Info{}
+	ts := mockTipSet(t)
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- scheduler.runFaultDeclarations(ctx, di, ts)
+	}()
+
+	msg := <-mockStgMinerAPI.pushedMessages
+	require.Equal(t, builtin.MethodsMiner.DeclareFaultsRecovered, msg.Method)
+
+	var params miner.DeclareFaultsRecoveredParams
+	require.NoError(t, params.UnmarshalCBOR(bytes.NewReader(msg.Params)))
+	require.Len(t, params.Recoveries, 1)
+
+	recovered, err := params.Recoveries[0].Sectors.IsSet(0)
+	require.NoError(t, err)
+	require.True(t, recovered)
+
+	require.NoError(t, <-errCh)
+}
+
 func mockTipSet(t *testing.T) *types.TipSet {
 	minerAct := tutils.NewActorAddr(t, "miner")
 	c, err := cid.Decode("QmbFMke1KXqnYyBBWxB74N4c5SBnJMVAiMNRcGu6x1AwQH")
@@ -251,14 +572,6 @@ func (m *mockStorageMinerAPI) StateMarketStorageDeal(ctx context.Context, id abi
 	panic("implement me")
 }
 
-func (m *mockStorageMinerAPI) StateMinerFaults(ctx context.Context, address address.Address, key types.TipSetKey) (bitfield.BitField, error) {
-	panic("implement me")
-}
-
-func (m *mockStorageMinerAPI) StateMinerRecoveries(ctx context.Context, address address.Address, key types.TipSetKey) (bitfield.BitField, error) {
-	panic("implement me")
-}
-
 func (m *mockStorageMinerAPI) StateAccountKey(ctx context.Context, address address.Address, key types.TipSetKey) (address.Address, error) {
 	panic("implement me")
 }